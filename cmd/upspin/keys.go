@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+
+	"upspin.googlesource.com/upspin.git/key/keyloader"
+	"upspin.googlesource.com/upspin.git/key/words"
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+// keysCommand implements "upspin keys new|update|list|recover".
+func keysCommand(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: upspin keys new|update|list|recover [arguments]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "new":
+		keysNew(args[1:])
+	case "update":
+		keysUpdate(args[1:])
+	case "list":
+		keysList(args[1:])
+	case "recover":
+		keysRecover(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "upspin keys: unknown subcommand %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func keysNew(args []string) {
+	fs := flag.NewFlagSet("keys new", flag.ExitOnError)
+	user := fs.String("user", "", "user name owning the new key pair")
+	curveName := fs.String("curve", "p256", "elliptic curve: p256 or p521")
+	fs.Parse(args)
+	if *user == "" {
+		fmt.Fprintln(os.Stderr, "upspin keys new: -user is required")
+		os.Exit(2)
+	}
+
+	curve, err := curveFor(*curveName)
+	if err != nil {
+		exitf(err)
+	}
+	priv, err := generateKey(curve)
+	if err != nil {
+		exitf(err)
+	}
+	printRecoveryPhrase(priv, curve)
+
+	passphrase := confirmedPassphrase()
+	ctx := &upspin.Context{UserName: upspin.UserName(*user), PrivateKey: priv}
+	if err := keyloader.Save(ctx, passphrase); err != nil {
+		exitf(err)
+	}
+	fmt.Printf("New key pair saved for %s.\n", *user)
+}
+
+func keysUpdate(args []string) {
+	fs := flag.NewFlagSet("keys update", flag.ExitOnError)
+	user := fs.String("user", "", "user name whose key pair is being re-encrypted")
+	fs.Parse(args)
+	if *user == "" {
+		fmt.Fprintln(os.Stderr, "upspin keys update: -user is required")
+		os.Exit(2)
+	}
+
+	ctx := &upspin.Context{UserName: upspin.UserName(*user)}
+	if err := keyloader.Load(ctx); err != nil {
+		exitf(err)
+	}
+	passphrase := confirmedPassphrase()
+	if err := keyloader.Save(ctx, passphrase); err != nil {
+		exitf(err)
+	}
+	fmt.Printf("Key pair for %s re-encrypted.\n", *user)
+}
+
+func keysList(args []string) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		exitf(fmt.Errorf("HOME is not set"))
+	}
+	dir := filepath.Join(home, ".ssh")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		exitf(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".upspinkeystore") {
+			fmt.Println(strings.TrimSuffix(e.Name(), ".upspinkeystore"))
+		}
+	}
+}
+
+func curveFor(name string) (elliptic.Curve, error) {
+	switch name {
+	case "p256":
+		return elliptic.P256(), nil
+	case "p521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unknown curve %q", name)
+}
+
+func generateKey(curve elliptic.Curve) (upspin.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	return upspin.PrivateKey{
+		Public:  upspin.PublicKey(fmt.Sprintf("%s\n%s", key.X.String(), key.Y.String())),
+		Private: []byte(key.D.String()),
+	}, nil
+}
+
+// printRecoveryPhrase prints the mnemonic backup phrase for priv so
+// the user can write it down; it is never written to disk.
+func printRecoveryPhrase(priv upspin.PrivateKey, curve elliptic.Curve) {
+	d, ok := new(big.Int).SetString(string(priv.Private), 10)
+	if !ok {
+		exitf(fmt.Errorf("internal error: malformed generated key"))
+	}
+	scalarLen := words.ScalarLenFor(curve.Params().BitSize)
+	scalar := make([]byte, scalarLen)
+	d.FillBytes(scalar)
+	phrase, err := words.ToMnemonic(scalar)
+	if err != nil {
+		exitf(err)
+	}
+	fmt.Println("Write down this recovery phrase and store it somewhere safe;")
+	fmt.Println("anyone who has it can recover your key:")
+	fmt.Println()
+	fmt.Println(phrase)
+	fmt.Println()
+}
+
+func confirmedPassphrase() string {
+	p1 := promptPassphrase("Enter passphrase: ")
+	p2 := promptPassphrase("Confirm passphrase: ")
+	if p1 != p2 {
+		exitf(fmt.Errorf("passphrases did not match"))
+	}
+	return p1
+}
+
+// promptPassphrase reads a passphrase from the terminal with echo
+// disabled, so it never appears on screen or in a scrollback buffer,
+// and returns the line exactly as typed, spaces and all.
+func promptPassphrase(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		exitf(fmt.Errorf("reading passphrase: %v", err))
+	}
+	return string(line)
+}
+
+func exitf(err error) {
+	fmt.Fprintf(os.Stderr, "upspin keys: %v\n", err)
+	os.Exit(1)
+}
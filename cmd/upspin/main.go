@@ -0,0 +1,33 @@
+// Command upspin is the command-line client for Upspin.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands maps a subcommand name to its implementation. Each one
+// parses os.Args[2:] itself.
+var commands = map[string]func([]string){
+	"keys": keysCommand,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	cmd(os.Args[2:])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: upspin <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for name := range commands {
+		fmt.Fprintf(os.Stderr, "\t%s\n", name)
+	}
+	os.Exit(2)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"upspin.googlesource.com/upspin.git/key/keyloader"
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+// keysRecover implements "upspin keys recover": it rebuilds a key
+// pair from a mnemonic phrase printed by a prior "upspin keys new"
+// and saves it to the user's keystore.
+func keysRecover(args []string) {
+	fs := flag.NewFlagSet("keys recover", flag.ExitOnError)
+	user := fs.String("user", "", "user name the recovered key pair belongs to")
+	curveName := fs.String("curve", "p256", "elliptic curve the phrase was generated with: p256 or p521")
+	fs.Parse(args)
+	if *user == "" {
+		fmt.Fprintln(os.Stderr, "upspin keys recover: -user is required")
+		os.Exit(2)
+	}
+
+	curve, err := curveFor(*curveName)
+	if err != nil {
+		exitf(err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Enter the recovery phrase, then press enter:")
+	phrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		exitf(err)
+	}
+
+	ctx := &upspin.Context{UserName: upspin.UserName(*user)}
+	if err := keyloader.LoadFromMnemonic(ctx, strings.TrimSpace(phrase), curve); err != nil {
+		exitf(err)
+	}
+
+	passphrase := confirmedPassphrase()
+	if err := keyloader.Save(ctx, passphrase); err != nil {
+		exitf(err)
+	}
+	fmt.Printf("Recovered key pair saved for %s.\n", *user)
+}
@@ -0,0 +1,147 @@
+// Package keyloader loads a user's Upspin key pair into an
+// upspin.Context.
+package keyloader
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+
+	"upspin.googlesource.com/upspin.git/key/keystore"
+	"upspin.googlesource.com/upspin.git/key/words"
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+const (
+	publicKeyFile  = "public.upspinkey"
+	privateKeyFile = "secret.upspinkey"
+)
+
+// Load populates ctx.PrivateKey with the user's key pair. If
+// ctx.PrivateKey is already set (for example by a test), Load does
+// nothing.
+//
+// Load first looks for an encrypted keystore file (see package
+// key/keystore) for ctx.UserName and, if found, decrypts it using
+// ctx.Passphrase or, if that is empty, a passphrase read from the
+// terminal. Failing that, it falls back to the legacy plain-text key
+// pair under $HOME/.ssh, for users who have not yet migrated.
+func Load(ctx *upspin.Context) error {
+	if len(ctx.PrivateKey.Private) > 0 {
+		return nil
+	}
+	dir, err := sshDir()
+	if err != nil {
+		return err
+	}
+
+	ksFile := keystore.FileName(dir, ctx.UserName)
+	if _, err := os.Stat(ksFile); err == nil {
+		return loadFromKeystore(ctx, ksFile)
+	}
+
+	return loadLegacy(ctx, dir)
+}
+
+func loadFromKeystore(ctx *upspin.Context, file string) error {
+	passphrase := ctx.Passphrase
+	if passphrase == "" {
+		p, err := readPassphrase(fmt.Sprintf("passphrase for %s: ", ctx.UserName))
+		if err != nil {
+			return err
+		}
+		passphrase = p
+	}
+	priv, err := keystore.Load(file, passphrase)
+	if err != nil {
+		return err
+	}
+	ctx.PrivateKey = priv
+	return nil
+}
+
+func loadLegacy(ctx *upspin.Context, dir string) error {
+	pub, err := ioutil.ReadFile(filepath.Join(dir, publicKeyFile))
+	if err != nil {
+		return err
+	}
+	priv, err := ioutil.ReadFile(filepath.Join(dir, privateKeyFile))
+	if err != nil {
+		return err
+	}
+	ctx.PrivateKey = upspin.PrivateKey{
+		Public:  upspin.PublicKey(strings.TrimSpace(string(pub))),
+		Private: []byte(strings.TrimSpace(string(priv))),
+	}
+	return nil
+}
+
+// LoadFromMnemonic recovers a key pair from a mnemonic phrase
+// produced by key/words.ToMnemonic and populates ctx.PrivateKey with
+// it. curve must match the one used to generate the original key
+// (elliptic.P256() or elliptic.P521()). If ctx.PrivateKey.Public is
+// already set, LoadFromMnemonic verifies that the recovered key
+// matches it before returning.
+func LoadFromMnemonic(ctx *upspin.Context, phrase string, curve elliptic.Curve) error {
+	scalarLen := words.ScalarLenFor(curve.Params().BitSize)
+	scalar, err := words.FromMnemonic(phrase, scalarLen)
+	if err != nil {
+		return err
+	}
+	d := new(big.Int).SetBytes(scalar)
+	x, y := curve.ScalarBaseMult(scalar)
+	public := upspin.PublicKey(fmt.Sprintf("%s\n%s", x.String(), y.String()))
+
+	if ctx.PrivateKey.Public != "" && ctx.PrivateKey.Public != public {
+		return errors.New("keyloader: recovered key does not match the stored public key")
+	}
+	ctx.PrivateKey = upspin.PrivateKey{
+		Public:  public,
+		Private: []byte(d.String()),
+	}
+	return nil
+}
+
+// Save encrypts ctx.PrivateKey under passphrase and writes it to the
+// user's keystore file, creating $HOME/.ssh if necessary.
+func Save(ctx *upspin.Context, passphrase string) error {
+	dir, err := sshDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return keystore.Save(keystore.FileName(dir, ctx.UserName), ctx.PrivateKey, passphrase)
+}
+
+func sshDir() (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE") // Windows.
+	}
+	if home == "" {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(home, ".ssh"), nil
+}
+
+// readPassphrase prompts on stderr and reads a passphrase from stdin
+// with echo disabled, so it never appears on screen or in a scrollback
+// buffer. It is a package variable so tests can stub it out.
+var readPassphrase = func(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
@@ -0,0 +1,89 @@
+package keyloader
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"upspin.googlesource.com/upspin.git/key/words"
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+func TestLoadFromMnemonicRoundTrip(t *testing.T) {
+	curve := elliptic.P256()
+	scalarLen := words.ScalarLenFor(curve.Params().BitSize)
+	scalar := make([]byte, scalarLen)
+	for i := range scalar {
+		scalar[i] = byte(i + 3)
+	}
+	d := new(big.Int).SetBytes(scalar)
+	x, y := curve.ScalarBaseMult(scalar)
+
+	phrase, err := words.ToMnemonic(scalar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &upspin.Context{UserName: "user@google.com"}
+	if err := LoadFromMnemonic(ctx, phrase, curve); err != nil {
+		t.Fatal("LoadFromMnemonic: ", err)
+	}
+	wantPublic := upspin.PublicKey(fmt.Sprintf("%s\n%s", x.String(), y.String()))
+	if ctx.PrivateKey.Public != wantPublic {
+		t.Errorf("recovered public key: got %q, want %q", ctx.PrivateKey.Public, wantPublic)
+	}
+	if string(ctx.PrivateKey.Private) != d.String() {
+		t.Errorf("recovered private key: got %q, want %q", ctx.PrivateKey.Private, d.String())
+	}
+}
+
+func TestLoadFromMnemonicMismatchedPublicKey(t *testing.T) {
+	curve := elliptic.P256()
+	scalarLen := words.ScalarLenFor(curve.Params().BitSize)
+	scalar := make([]byte, scalarLen)
+	for i := range scalar {
+		scalar[i] = byte(i + 3)
+	}
+	phrase, err := words.ToMnemonic(scalar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &upspin.Context{
+		UserName:   "user@google.com",
+		PrivateKey: upspin.PrivateKey{Public: upspin.PublicKey("wrong\nkey")},
+	}
+	if err := LoadFromMnemonic(ctx, phrase, curve); err == nil {
+		t.Fatal("LoadFromMnemonic: expected a mismatch error, got none")
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	home, err := ioutil.TempDir("", "keyloader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx := &upspin.Context{
+		UserName:   "user@google.com",
+		PrivateKey: upspin.PrivateKey{Public: upspin.PublicKey("1\n2"), Private: []byte("42")},
+	}
+	if err := Save(ctx, "passphrase"); err != nil {
+		t.Fatal("Save: ", err)
+	}
+
+	loaded := &upspin.Context{UserName: "user@google.com", Passphrase: "passphrase"}
+	if err := Load(loaded); err != nil {
+		t.Fatal("Load: ", err)
+	}
+	if loaded.PrivateKey.Public != ctx.PrivateKey.Public || string(loaded.PrivateKey.Private) != string(ctx.PrivateKey.Private) {
+		t.Fatalf("Load: got %+v, want %+v", loaded.PrivateKey, ctx.PrivateKey)
+	}
+}
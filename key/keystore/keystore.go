@@ -0,0 +1,177 @@
+// Package keystore implements passphrase-protected, at-rest storage
+// for Upspin private keys, modeled on the go-ethereum keystore: each
+// key is stored as a JSON envelope containing the parameters needed
+// to re-derive its encryption key from a passphrase, never the
+// passphrase or the raw scalar itself.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/sha3"
+
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+// Scrypt parameters. These match the defaults used by the Ethereum
+// keystore and are expensive enough (~1s on commodity hardware in
+// 2016) to make offline brute-forcing of a stolen keystore file
+// impractical.
+const (
+	scryptN      = 1 << 18 // 262144
+	scryptR      = 8
+	scryptP      = 1
+	scryptDKLen  = 32
+	saltLen      = 32
+	ivLen        = 16
+	keystoreVers = 1
+)
+
+// envelope is the on-disk JSON representation of an encrypted key.
+type envelope struct {
+	Version int    `json:"version"`
+	Public  string `json:"public"`  // upspin.PublicKey, verbatim.
+	Salt    string `json:"salt"`    // hex.
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	IV      string `json:"iv"`      // hex.
+	Cipher  string `json:"cipher"`  // hex, AES-CTR of the scalar.
+	MAC     string `json:"mac"`     // hex, SHA3-256(derivedKey[16:32] || cipher).
+}
+
+// FileName returns the path of the keystore file for the named user
+// under dir (typically $HOME/.ssh).
+func FileName(dir string, name upspin.UserName) string {
+	return filepath.Join(dir, sanitize(string(name))+".upspinkeystore")
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Save encrypts priv under passphrase and writes it to file,
+// overwriting any existing contents.
+func Save(file string, priv upspin.PrivateKey, passphrase string) error {
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, ivLen)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(derived[:16])
+	if err != nil {
+		return err
+	}
+	ciphertext := make([]byte, len(priv.Private))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, priv.Private)
+
+	mac := macOf(derived, ciphertext)
+
+	env := envelope{
+		Version: keystoreVers,
+		Public:  string(priv.Public),
+		Salt:    hex.EncodeToString(salt),
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		IV:      hex.EncodeToString(iv),
+		Cipher:  hex.EncodeToString(ciphertext),
+		MAC:     hex.EncodeToString(mac),
+	}
+	data, err := json.MarshalIndent(env, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// Load decrypts the keystore at file using passphrase and returns the
+// recovered private key.
+func Load(file string, passphrase string) (upspin.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return upspin.PrivateKey{}, fmt.Errorf("keystore: malformed keystore %q: %v", file, err)
+	}
+	if env.Version != keystoreVers {
+		return upspin.PrivateKey{}, fmt.Errorf("keystore: unsupported version %d", env.Version)
+	}
+	salt, err := hex.DecodeString(env.Salt)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	iv, err := hex.DecodeString(env.IV)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	ciphertext, err := hex.DecodeString(env.Cipher)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	wantMAC, err := hex.DecodeString(env.MAC)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+
+	derived, err := scrypt.Key([]byte(passphrase), salt, env.N, env.R, env.P, scryptDKLen)
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	gotMAC := macOf(derived, ciphertext)
+	if subtle.ConstantTimeCompare(gotMAC, wantMAC) != 1 {
+		return upspin.PrivateKey{}, errors.New("keystore: incorrect passphrase")
+	}
+
+	block, err := aes.NewCipher(derived[:16])
+	if err != nil {
+		return upspin.PrivateKey{}, err
+	}
+	scalar := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(scalar, ciphertext)
+
+	return upspin.PrivateKey{
+		Public:  upspin.PublicKey(env.Public),
+		Private: scalar,
+	}, nil
+}
+
+// macOf computes the integrity tag over a wrapped key: SHA3-256 of
+// the second half of the derived key concatenated with the
+// ciphertext. Binding the MAC to derived[16:32] (rather than
+// derived[:16], used as the AES key) means a wrong passphrase is
+// detected without ever touching the AES-CTR keystream.
+func macOf(derived, ciphertext []byte) []byte {
+	h := sha3.New256()
+	h.Write(derived[16:32])
+	h.Write(ciphertext)
+	return h.Sum(nil)
+}
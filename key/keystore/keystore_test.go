@@ -0,0 +1,101 @@
+package keystore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv := upspin.PrivateKey{
+		Public:  upspin.PublicKey("1\n2"),
+		Private: []byte("12345678901234567890"),
+	}
+	file := FileName(dir, "user@google.com")
+
+	if err := Save(file, priv, "correct horse battery staple"); err != nil {
+		t.Fatal("Save: ", err)
+	}
+	got, err := Load(file, "correct horse battery staple")
+	if err != nil {
+		t.Fatal("Load: ", err)
+	}
+	if got.Public != priv.Public || string(got.Private) != string(priv.Private) {
+		t.Fatalf("Load: got %+v, want %+v", got, priv)
+	}
+}
+
+func TestLoadWrongPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv := upspin.PrivateKey{Public: upspin.PublicKey("1\n2"), Private: []byte("42")}
+	file := FileName(dir, "user@google.com")
+	if err := Save(file, priv, "right passphrase"); err != nil {
+		t.Fatal("Save: ", err)
+	}
+	if _, err := Load(file, "wrong passphrase"); err == nil {
+		t.Fatal("Load: expected an error for a wrong passphrase, got none")
+	}
+}
+
+func TestLoadTamperedCiphertext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv := upspin.PrivateKey{Public: upspin.PublicKey("1\n2"), Private: []byte("42")}
+	file := FileName(dir, "user@google.com")
+	if err := Save(file, priv, "passphrase"); err != nil {
+		t.Fatal("Save: ", err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	// Flip a hex nibble in the ciphertext; the MAC should catch it.
+	flipped := "f"
+	if env.Cipher[0] == 'f' {
+		flipped = "0"
+	}
+	env.Cipher = flipped + env.Cipher[1:]
+	tampered, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(file, "passphrase"); err == nil {
+		t.Fatal("Load: expected a MAC mismatch after tampering with the ciphertext, got none")
+	}
+}
+
+func TestFileName(t *testing.T) {
+	got := FileName(filepath.Join("home", ".ssh"), "user@google.com")
+	want := filepath.Join("home", ".ssh", "user@google.com.upspinkeystore")
+	if got != want {
+		t.Errorf("FileName: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+package words
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed data/english.txt
+var englishWordlist string
+
+// english is the BIP39 English wordlist (2048 words), read from
+// data/english.txt at build time so the two never drift out of sync.
+var english = strings.Fields(englishWordlist)
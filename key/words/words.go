@@ -0,0 +1,137 @@
+// Package words converts Upspin elliptic-curve private key scalars to
+// and from a short, human-copyable mnemonic phrase, BIP39-style, so
+// that a key can be backed up on paper instead of (or in addition to)
+// a digital keystore.
+//
+// Every byte of the scalar plus a one-byte checksum is split into
+// 11-bit groups, each of which indexes a word in the embedded English
+// wordlist. Curve p256 scalars (32 bytes, 33 with checksum) yield 24
+// words; curve p521 scalars (66 bytes, 67 with checksum) yield 49
+// words, the last holding only 8 payload bits and 3 bits of
+// zero-padding.
+package words
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const wordBits = 11 // log2(len(english)).
+
+// ToMnemonic converts the private scalar bytes of an Upspin key into
+// a space-separated mnemonic phrase.
+func ToMnemonic(scalar []byte) (string, error) {
+	if len(scalar) == 0 {
+		return "", errors.New("words: empty scalar")
+	}
+	checksum := sha256.Sum256(scalar)
+	payload := append(append([]byte{}, scalar...), checksum[0])
+
+	bits := newBitReader(payload)
+	n := (len(payload)*8 + wordBits - 1) / wordBits
+	out := make([]string, n)
+	for i := 0; i < n; i++ {
+		idx := bits.next(wordBits)
+		if int(idx) >= len(english) {
+			return "", fmt.Errorf("words: index %d out of range", idx)
+		}
+		out[i] = english[idx]
+	}
+	return strings.Join(out, " "), nil
+}
+
+// FromMnemonic reverses ToMnemonic, returning the original scalar
+// bytes and verifying the embedded checksum.
+func FromMnemonic(phrase string, scalarLen int) ([]byte, error) {
+	fields := strings.Fields(phrase)
+	index := make(map[string]uint32, len(english))
+	for i, w := range english {
+		index[w] = uint32(i)
+	}
+
+	bits := newBitWriter()
+	for _, w := range fields {
+		idx, ok := index[w]
+		if !ok {
+			return nil, fmt.Errorf("words: unknown word %q", w)
+		}
+		bits.write(idx, wordBits)
+	}
+	payloadBits := (scalarLen + 1) * 8
+	if bits.n < payloadBits {
+		return nil, errors.New("words: mnemonic too short for key size")
+	}
+	payload := bits.bytes(payloadBits)
+	scalar, checksum := payload[:scalarLen], payload[scalarLen]
+
+	want := sha256.Sum256(scalar)
+	if checksum != want[0] {
+		return nil, errors.New("words: checksum mismatch; mnemonic mistyped or wrong key size")
+	}
+	return scalar, nil
+}
+
+// ScalarLenFor returns the expected private-key byte length for a
+// curve bit size, as used by ToMnemonic/FromMnemonic (32 for P256, 66
+// for P521).
+func ScalarLenFor(curveBits int) int {
+	return (curveBits + 7) / 8
+}
+
+// bitReader walks a byte slice MSB-first in arbitrary-width chunks.
+type bitReader struct {
+	data []byte
+	pos  int // bit offset from the start of data.
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (r *bitReader) next(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v <<= 1
+		byteIdx := r.pos / 8
+		bitIdx := uint(7 - r.pos%8)
+		if byteIdx < len(r.data) && r.data[byteIdx]&(1<<bitIdx) != 0 {
+			v |= 1
+		}
+		r.pos++
+	}
+	return v
+}
+
+// bitWriter is the inverse of bitReader, accumulating arbitrary-width
+// chunks into a byte slice.
+type bitWriter struct {
+	acc big.Int
+	n   int // total bits written.
+}
+
+func newBitWriter() *bitWriter {
+	return &bitWriter{}
+}
+
+func (w *bitWriter) write(v uint32, n int) {
+	w.acc.Lsh(&w.acc, uint(n))
+	w.acc.Or(&w.acc, big.NewInt(int64(v)))
+	w.n += n
+}
+
+// bytes returns the first payloadBits bits written, MSB-first, as
+// ceil(payloadBits/8) bytes. ToMnemonic zero-pads at the tail of the
+// bit stream (after the real payload) to fill out a whole number of
+// 11-bit word groups, so what's written here can hold more bits than
+// payloadBits; that tail padding is the low-order end of w.acc and
+// must be shifted off before reading the payload back out, or every
+// byte comes out realigned by however many padding bits there are.
+func (w *bitWriter) bytes(payloadBits int) []byte {
+	v := new(big.Int).Rsh(&w.acc, uint(w.n-payloadBits))
+	out := make([]byte, (payloadBits+7)/8)
+	v.FillBytes(out)
+	return out
+}
@@ -0,0 +1,58 @@
+package words
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestMnemonicRoundTrip(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P521()} {
+		scalarLen := ScalarLenFor(curve.Params().BitSize)
+		scalar := make([]byte, scalarLen)
+		for i := range scalar {
+			scalar[i] = byte(i*7 + 1)
+		}
+
+		phrase, err := ToMnemonic(scalar)
+		if err != nil {
+			t.Fatalf("curve %v: ToMnemonic: %v", curve.Params().Name, err)
+		}
+
+		got, err := FromMnemonic(phrase, scalarLen)
+		if err != nil {
+			t.Fatalf("curve %v: FromMnemonic: %v", curve.Params().Name, err)
+		}
+		if new(big.Int).SetBytes(got).Cmp(new(big.Int).SetBytes(scalar)) != 0 {
+			t.Fatalf("curve %v: round trip mismatch: got %x, want %x", curve.Params().Name, got, scalar)
+		}
+	}
+}
+
+func TestMnemonicChecksumMismatch(t *testing.T) {
+	scalar := make([]byte, ScalarLenFor(elliptic.P256().Params().BitSize))
+	for i := range scalar {
+		scalar[i] = byte(i + 1)
+	}
+	phrase, err := ToMnemonic(scalar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the first two words, which changes the encoded bits
+	// without changing the word count.
+	words := strings.Fields(phrase)
+	words[0], words[1] = words[1], words[0]
+	mangled := strings.Join(words, " ")
+
+	if _, err := FromMnemonic(mangled, len(scalar)); err == nil {
+		t.Fatal("FromMnemonic: expected a checksum mismatch, got none")
+	}
+}
+
+func TestMnemonicUnknownWord(t *testing.T) {
+	if _, err := FromMnemonic("not a real bip39 word at all", 32); err == nil {
+		t.Fatal("FromMnemonic: expected an error for an unknown word, got none")
+	}
+}
@@ -0,0 +1,644 @@
+// Package ee implements upspin.Packers that encrypt and sign data
+// using elliptic-curve cryptography: ECDH key agreement, against a
+// one-time ephemeral key generated for the file, wraps a per-file
+// data key for each reader, an AEAD cipher seals the content, and
+// ECDSA signs the result under the owner's key. The ephemeral key
+// travels in the packed metadata, so recovering the data key needs no
+// User.Lookup round trip, regardless of who's reading.
+//
+// Verifying the signature is a different story: unless the reader
+// already owns the file, nothing in PackData can be trusted as the
+// verification key, since whoever wrote PackData controls every byte
+// of it, Owner included. So Unpack and UnpackChunk still make one
+// ctx.User.Lookup call per Unpack for every file someone else owns,
+// exactly as before this package started embedding Owner in
+// PackData, with no cache to avoid repeating it on a second read of
+// the same file. Embedding Ephemeral makes data-key recovery offline
+// for everyone; it does not make reading a file someone else owns
+// offline overall, because authenticating that owner's key still
+// needs the network.
+//
+// The AEAD cipher is pluggable per suite (see the newAEAD field of
+// suite), which is what lets EEp256Pack/EEp521Pack use AES-GCM while
+// EEChaChaP256Pack uses XChaCha20-Poly1305 without duplicating the
+// surrounding key-agreement and signing logic.
+package ee
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"upspin.googlesource.com/upspin.git/pack"
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+// suite is the shared implementation behind every EE packing; only
+// the curve and the AEAD construction differ between them.
+type suite struct {
+	packing    upspin.Packing
+	curve      elliptic.Curve
+	newAEAD    func(key []byte) (cipher.AEAD, error)
+	nonceSize  int
+	overhead   int
+}
+
+var (
+	eep256 = suite{
+		packing:   upspin.EEp256Pack,
+		curve:     elliptic.P256(),
+		newAEAD:   newAESGCM,
+		nonceSize: 12,
+		overhead:  16,
+	}
+	eep521 = suite{
+		packing:   upspin.EEp521Pack,
+		curve:     elliptic.P521(),
+		newAEAD:   newAESGCM,
+		nonceSize: 12,
+		overhead:  16,
+	}
+	eeChaChaP256 = suite{
+		packing:   upspin.EEChaChaP256Pack,
+		curve:     elliptic.P256(),
+		newAEAD:   chacha20poly1305.NewX,
+		nonceSize: chacha20poly1305.NonceSizeX,
+		overhead:  chacha20poly1305.Overhead,
+	}
+)
+
+func init() {
+	pack.Register(eep256)
+	pack.Register(eep521)
+	pack.Register(eeChaChaP256)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+const (
+	dkeyLen          = 32      // data-key length; also the XChaCha20-Poly1305 and AES-256 key size.
+	defaultChunkSize = 1 << 20 // 1 MiB, overridable via Metadata.ChunkSize.
+)
+
+// dataKeySource is where Pack draws the per-file data key's entropy
+// from. It is always crypto/rand.Reader in production; tests
+// substitute a deterministic reader to confirm Pack asks it for
+// exactly dkeyLen bytes, straight from the source, with no weaker
+// PRNG mixed in along the way.
+var dataKeySource io.Reader = rand.Reader
+
+// wrappedKey is the encryption of a file's data key under the
+// ECDH-derived shared key for one reader.
+type wrappedKey struct {
+	Reader upspin.PublicKey
+	Nonce  []byte
+	Cipher []byte
+}
+
+// packMeta is gob-encoded into upspin.Metadata.PackData. The file is
+// split into chunks of ChunkSize bytes (the last one may be
+// shorter); ChunkHashes holds the Merkle leaf hash of each chunk's
+// ciphertext, and R,S sign the Merkle root rather than the content
+// itself, so a chunk can be verified via UnpackChunk without its
+// siblings.
+//
+// Ephemeral is a one-time public key generated for this Pack call
+// whose matching private scalar was used, ECIES-style, to wrap the
+// data key for every reader; a reader recovers the data key from
+// their own private key and Ephemeral alone, with no User.Lookup
+// round trip, which matters for reading offline. Owner is the file
+// owner's claimed public key, carried alongside the signature as a
+// hint, but it is not itself trusted: it comes from the same
+// untrusted PackData as R and S, so Unpack always re-resolves the
+// real owner's key by name (from ctx's own key when the reader owns
+// the file, otherwise via ctx.User.Lookup) and checks Owner against
+// that before the signature is allowed to mean anything.
+type packMeta struct {
+	ChunkSize   int
+	ChunkHashes [][]byte
+	Wrap        []wrappedKey
+	Owner       upspin.PublicKey
+	Ephemeral   upspin.PublicKey
+	R, S        *big.Int
+}
+
+func (s suite) Packing() upspin.Packing { return s.packing }
+
+func (s suite) chunkSize(meta *upspin.Metadata) int {
+	if meta.ChunkSize > 0 {
+		return meta.ChunkSize
+	}
+	return defaultChunkSize
+}
+
+func (s suite) PackLen(ctx *upspin.Context, data []byte, meta *upspin.Metadata, name upspin.PathName) int {
+	n, chunkSize := 0, s.chunkSize(meta)
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		n += (end - off) + s.overhead
+	}
+	return n
+}
+
+func (s suite) UnpackLen(ctx *upspin.Context, ciphertext []byte, meta *upspin.Metadata) int {
+	pm, err := decodePackMeta(meta)
+	if err != nil {
+		return 0
+	}
+	n := len(ciphertext) - len(pm.ChunkHashes)*s.overhead
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (s suite) Pack(ctx *upspin.Context, ciphertext, data []byte, meta *upspin.Metadata, name upspin.PathName) (int, error) {
+	if ctx.Packing != s.packing {
+		return 0, fmt.Errorf("ee: Pack: wrong packing %v for %v", ctx.Packing, s.packing)
+	}
+	if len(ciphertext) < s.PackLen(ctx, data, meta, name) {
+		return 0, errors.New("ee: Pack: ciphertext buffer too small")
+	}
+	priv, ownerPub, err := s.parseKeyPair(ctx.PrivateKey)
+	if err != nil {
+		return 0, err
+	}
+
+	dkey := make([]byte, dkeyLen)
+	if _, err := io.ReadFull(dataKeySource, dkey); err != nil {
+		return 0, err
+	}
+	chunkSize := s.chunkSize(meta)
+	n, hashes, err := s.sealChunks(ciphertext, dkey, data, chunkSize)
+	if err != nil {
+		return 0, err
+	}
+	root := merkleRoot(hashes)
+
+	// Wrap each reader's data key under a one-time ephemeral key
+	// rather than the owner's own, so Unpack never needs the owner's
+	// key to recover the data key: the ephemeral public key travels
+	// with the file. (Unpack still looks the owner up to verify the
+	// signature when the reader does not own the file.)
+	ephPriv, ephX, ephY, err := elliptic.GenerateKey(s.curve, rand.Reader)
+	if err != nil {
+		return 0, err
+	}
+	ephScalar := new(big.Int).SetBytes(ephPriv)
+	ephemeralPub := upspin.PublicKey(fmt.Sprintf("%s\n%s", ephX.String(), ephY.String()))
+
+	readers := append([]upspin.UserName{ctx.UserName}, meta.Readers...)
+	wrap := make([]wrappedKey, 0, len(readers))
+	for _, reader := range readers {
+		pub := ownerPub
+		if reader != ctx.UserName {
+			pub, err = s.lookupPublic(ctx, reader)
+			if err != nil {
+				return 0, err
+			}
+		}
+		w, err := s.wrap(ephScalar, pub, dkey)
+		if err != nil {
+			return 0, err
+		}
+		wrap = append(wrap, w)
+	}
+
+	signingKey, err := s.ecdsaPrivateKey(priv, ownerPub)
+	if err != nil {
+		return 0, err
+	}
+	r, sig, err := ecdsa.Sign(rand.Reader, signingKey, root)
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	pm := packMeta{
+		ChunkSize:   chunkSize,
+		ChunkHashes: hashes,
+		Wrap:        wrap,
+		Owner:       ownerPub,
+		Ephemeral:   ephemeralPub,
+		R:           r,
+		S:           sig,
+	}
+	if err := gob.NewEncoder(&buf).Encode(pm); err != nil {
+		return 0, err
+	}
+	meta.PackData = buf.Bytes()
+
+	return n, nil
+}
+
+func (s suite) Unpack(ctx *upspin.Context, data, ciphertext []byte, meta *upspin.Metadata, name upspin.PathName) (int, error) {
+	if ctx.Packing != s.packing {
+		return 0, fmt.Errorf("ee: Unpack: wrong packing %v for %v", ctx.Packing, s.packing)
+	}
+	_, myPub, err := s.parseKeyPair(ctx.PrivateKey)
+	if err != nil {
+		return 0, err
+	}
+
+	pm, err := decodePackMeta(meta)
+	if err != nil {
+		return 0, err
+	}
+	ownerPub, err := s.trustedOwnerKey(ctx, name, pm)
+	if err != nil {
+		return 0, err
+	}
+
+	chunks := splitCiphertext(ciphertext, pm.ChunkSize, s.overhead)
+	if len(chunks) != len(pm.ChunkHashes) {
+		return 0, errors.New("ee: Unpack: chunk count does not match Metadata")
+	}
+	hashes := make([][]byte, len(chunks))
+	for i, c := range chunks {
+		hashes[i] = merkleLeaf(c)
+	}
+	root := merkleRoot(hashes)
+	if pm.R == nil || pm.S == nil {
+		return 0, errors.New("ee: Unpack: missing signature in PackData")
+	}
+	verifyKey, err := s.ecdsaPublicKey(ownerPub)
+	if err != nil {
+		return 0, err
+	}
+	if ok := ecdsa.Verify(verifyKey, root, pm.R, pm.S); !ok {
+		return 0, errors.New("ee: Unpack: signature verification failed")
+	}
+
+	w, err := pm.wrapFor(myPub)
+	if err != nil {
+		return 0, err
+	}
+	dkey, err := s.unwrap(ctx, pm.Ephemeral, *w)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i, c := range chunks {
+		m, err := s.openChunk(data[n:], dkey, i, c)
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// UnpackChunk verifies and decrypts a single chunk against the
+// Merkle root signed in meta.PackData, using only that chunk's
+// ciphertext and inclusion proof: the rest of the file need not be
+// present.
+func (s suite) UnpackChunk(ctx *upspin.Context, meta *upspin.Metadata, name upspin.PathName, i int, proof [][]byte, ciphertext []byte) ([]byte, error) {
+	_, myPub, err := s.parseKeyPair(ctx.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := decodePackMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= len(pm.ChunkHashes) {
+		return nil, fmt.Errorf("ee: UnpackChunk: chunk index %d out of range", i)
+	}
+	ownerPub, err := s.trustedOwnerKey(ctx, name, pm)
+	if err != nil {
+		return nil, err
+	}
+
+	root := merkleRoot(pm.ChunkHashes)
+	if pm.R == nil || pm.S == nil {
+		return nil, errors.New("ee: UnpackChunk: missing signature in PackData")
+	}
+	verifyKey, err := s.ecdsaPublicKey(ownerPub)
+	if err != nil {
+		return nil, err
+	}
+	if ok := ecdsa.Verify(verifyKey, root, pm.R, pm.S); !ok {
+		return nil, errors.New("ee: UnpackChunk: signature verification failed")
+	}
+	if !merkleVerify(merkleLeaf(ciphertext), i, proof, root) {
+		return nil, errors.New("ee: UnpackChunk: Merkle proof verification failed")
+	}
+
+	w, err := pm.wrapFor(myPub)
+	if err != nil {
+		return nil, err
+	}
+	dkey, err := s.unwrap(ctx, pm.Ephemeral, *w)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(ciphertext)-s.overhead)
+	if _, err := s.openChunk(data, dkey, i, ciphertext); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ChunkProof returns the Merkle inclusion proof for chunk i, for
+// passing to UnpackChunk by a caller that only has that chunk's
+// ciphertext on hand.
+func (s suite) ChunkProof(meta *upspin.Metadata, i int) ([][]byte, error) {
+	pm, err := decodePackMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= len(pm.ChunkHashes) {
+		return nil, fmt.Errorf("ee: ChunkProof: chunk index %d out of range", i)
+	}
+	return merkleProof(pm.ChunkHashes, i), nil
+}
+
+// wrapFor returns the wrapped data key addressed to pub. The
+// comparison runs in constant time over the full list so that how
+// quickly a match is found can't be used to narrow down a reader's
+// position among the wrapped entries.
+func (pm packMeta) wrapFor(pub upspin.PublicKey) (*wrappedKey, error) {
+	want := []byte(pub)
+	found := -1
+	for i := range pm.Wrap {
+		have := []byte(pm.Wrap[i].Reader)
+		if len(have) == len(want) && subtle.ConstantTimeCompare(have, want) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, errors.New("ee: no wrapped key for this reader")
+	}
+	return &pm.Wrap[found], nil
+}
+
+func decodePackMeta(meta *upspin.Metadata) (packMeta, error) {
+	var pm packMeta
+	if err := gob.NewDecoder(bytes.NewReader(meta.PackData)).Decode(&pm); err != nil {
+		return packMeta{}, fmt.Errorf("ee: malformed PackData: %v", err)
+	}
+	if pm.ChunkSize <= 0 {
+		return packMeta{}, fmt.Errorf("ee: malformed PackData: non-positive ChunkSize %d", pm.ChunkSize)
+	}
+	return pm, nil
+}
+
+// splitCiphertext partitions ciphertext into the chunks Pack would
+// have produced for a file packed with the given chunkSize, so that
+// Unpack can re-derive per-chunk Merkle leaves and nonces.
+func splitCiphertext(ciphertext []byte, chunkSize, overhead int) [][]byte {
+	var chunks [][]byte
+	for off := 0; off < len(ciphertext); {
+		end := off + chunkSize + overhead
+		if end > len(ciphertext) {
+			end = len(ciphertext)
+		}
+		chunks = append(chunks, ciphertext[off:end])
+		off = end
+	}
+	return chunks
+}
+
+// sealChunks AEAD-encrypts data in chunkSize pieces, writing them
+// back to back into ciphertext, and returns the total bytes written
+// along with each chunk's Merkle leaf hash.
+func (s suite) sealChunks(ciphertext, dkey, data []byte, chunkSize int) (int, [][]byte, error) {
+	aead, err := s.newAEAD(dkey)
+	if err != nil {
+		return 0, nil, err
+	}
+	var hashes [][]byte
+	off := 0
+	for start := 0; start < len(data); start += chunkSize {
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		i := len(hashes)
+		nonce, err := chunkNonce(dkey, i, aead.NonceSize())
+		if err != nil {
+			return 0, nil, err
+		}
+		sealed := aead.Seal(ciphertext[off:off], nonce, data[start:end], nil)
+		hashes = append(hashes, merkleLeaf(sealed))
+		off += len(sealed)
+	}
+	return off, hashes, nil
+}
+
+// openChunk decrypts the i'th chunk's ciphertext into data and
+// returns the number of plaintext bytes written.
+func (s suite) openChunk(data, dkey []byte, i int, ciphertext []byte) (int, error) {
+	aead, err := s.newAEAD(dkey)
+	if err != nil {
+		return 0, err
+	}
+	nonce, err := chunkNonce(dkey, i, aead.NonceSize())
+	if err != nil {
+		return 0, err
+	}
+	out, err := aead.Open(data[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return 0, fmt.Errorf("ee: chunk %d: %v", i, err)
+	}
+	return len(out), nil
+}
+
+// chunkNonce derives a deterministic, unique-per-chunk AEAD nonce
+// from the file's data key and the chunk index via HKDF, so chunks
+// need not carry their own nonce.
+func chunkNonce(dkey []byte, index, size int) ([]byte, error) {
+	info := []byte(fmt.Sprintf("upspin/ee chunk %d", index))
+	nonce := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dkey, nil, info), nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// wrap encrypts dkey for the holder of pub using the ECDH shared
+// secret derived from priv and pub. Pack calls this with the
+// per-file ephemeral scalar, not ctx's own key, so the reader-side
+// GenSharedKey helper (which only knows ctx.PrivateKey) cannot be
+// reused here.
+func (s suite) wrap(priv *big.Int, pub upspin.PublicKey, dkey []byte) (wrappedKey, error) {
+	x, y, err := s.parsePublicPoint(pub)
+	if err != nil {
+		return wrappedKey{}, err
+	}
+	key := s.sharedKey(priv, x, y)
+	aead, err := s.newAEAD(key)
+	if err != nil {
+		return wrappedKey{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return wrappedKey{}, err
+	}
+	return wrappedKey{
+		Reader: pub,
+		Nonce:  nonce,
+		Cipher: aead.Seal(nil, nonce, dkey, nil),
+	}, nil
+}
+
+// unwrap reverses wrap: it recovers dkey using the ECDH shared secret
+// derived from ctx's own private key and pub (the two are the same
+// secret from either side).
+func (s suite) unwrap(ctx *upspin.Context, pub upspin.PublicKey, w wrappedKey) ([]byte, error) {
+	key, err := s.GenSharedKey(ctx, pub)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, w.Nonce, w.Cipher, nil)
+}
+
+// GenSharedKey derives the ECDH shared key between ctx.PrivateKey and
+// pub. It is exposed on upspin.Packer so that other ECDH-based
+// packers can reuse the same primitive instead of reimplementing it.
+func (s suite) GenSharedKey(ctx *upspin.Context, pub upspin.PublicKey) ([]byte, error) {
+	priv, _, err := s.parseKeyPair(ctx.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	x, y, err := s.parsePublicPoint(pub)
+	if err != nil {
+		return nil, err
+	}
+	return s.sharedKey(priv, x, y), nil
+}
+
+// sharedKey derives a symmetric key from an ECDH key agreement.
+func (s suite) sharedKey(priv *big.Int, x, y *big.Int) []byte {
+	sx, _ := s.curve.ScalarMult(x, y, priv.Bytes())
+	sum := sha256.Sum256(sx.Bytes())
+	return sum[:]
+}
+
+func (s suite) ecdsaPrivateKey(priv *big.Int, pub upspin.PublicKey) (*ecdsa.PrivateKey, error) {
+	pk, err := s.ecdsaPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PrivateKey{PublicKey: *pk, D: priv}, nil
+}
+
+func (s suite) ecdsaPublicKey(pub upspin.PublicKey) (*ecdsa.PublicKey, error) {
+	x, y, err := s.parsePublicPoint(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: s.curve, X: x, Y: y}, nil
+}
+
+// parseKeyPair parses priv into a scalar and returns it along with
+// its declared public key.
+func (s suite) parseKeyPair(priv upspin.PrivateKey) (*big.Int, upspin.PublicKey, error) {
+	d, ok := new(big.Int).SetString(strings.TrimSpace(string(priv.Private)), 10)
+	if !ok {
+		return nil, "", errors.New("ee: invalid private key")
+	}
+	return d, priv.Public, nil
+}
+
+// parsePublicPoint parses the two decimal coordinates of an Upspin
+// public key and checks that the resulting point actually lies on
+// curve. Points read out of PackData are attacker-controlled, and
+// Go's ScalarMult/ScalarBaseMult panic rather than error out when
+// given an off-curve point, so every caller needs this check before
+// the point reaches the curve arithmetic.
+func (s suite) parsePublicPoint(pub upspin.PublicKey) (x, y *big.Int, err error) {
+	parts := strings.Split(strings.TrimSpace(string(pub)), "\n")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("ee: malformed public key")
+	}
+	x, ok1 := new(big.Int).SetString(strings.TrimSpace(parts[0]), 10)
+	y, ok2 := new(big.Int).SetString(strings.TrimSpace(parts[1]), 10)
+	if !ok1 || !ok2 {
+		return nil, nil, fmt.Errorf("ee: malformed public key")
+	}
+	if !s.curve.IsOnCurve(x, y) {
+		return nil, nil, fmt.Errorf("ee: public key is not a point on %s", s.curve.Params().Name)
+	}
+	return x, y, nil
+}
+
+// lookupPublic fetches the public key for name via the Context's User
+// service.
+func (s suite) lookupPublic(ctx *upspin.Context, name upspin.UserName) (upspin.PublicKey, error) {
+	_, keys, err := ctx.User.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("ee: no known keys for %q", name)
+	}
+	return keys[0], nil
+}
+
+// trustedOwnerKey returns the public key that the ECDSA signature in
+// pm must be checked against. pm.Owner is whatever the writer of
+// PackData claims the owner's key is, so it is not itself a trust
+// anchor: anyone who can write a Store blob can set Owner to their
+// own key and sign with it. If the caller is unpacking their own
+// file, their own declared public key is the trust anchor and no
+// lookup is needed; otherwise the real owner's key is fetched by
+// name (the path's first component) via the User service. Either way,
+// pm.Owner must match what's trusted before it's used, so a forged
+// Owner field is caught rather than silently trusted.
+func (s suite) trustedOwnerKey(ctx *upspin.Context, name upspin.PathName, pm packMeta) (upspin.PublicKey, error) {
+	owner := ownerOf(name)
+	var ownerPub upspin.PublicKey
+	if owner == ctx.UserName {
+		ownerPub = ctx.PrivateKey.Public
+	} else {
+		pub, err := s.lookupPublic(ctx, owner)
+		if err != nil {
+			return "", err
+		}
+		ownerPub = pub
+	}
+	have, want := []byte(pm.Owner), []byte(ownerPub)
+	if len(have) != len(want) || subtle.ConstantTimeCompare(have, want) != 1 {
+		return "", fmt.Errorf("ee: PackData owner key does not match %q's known key", owner)
+	}
+	return ownerPub, nil
+}
+
+// ownerOf returns the user name that owns the given path, i.e. its
+// first slash-separated component.
+func ownerOf(name upspin.PathName) upspin.UserName {
+	str := string(name)
+	if i := strings.IndexByte(str, '/'); i >= 0 {
+		str = str[:i]
+	}
+	return upspin.UserName(str)
+}
@@ -1,10 +1,16 @@
 package ee
 
 import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/gob"
 	"errors"
+	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 
-	"upspin.googlesource.com/upspin.git/key/keyloader"
 	"upspin.googlesource.com/upspin.git/pack"
 	"upspin.googlesource.com/upspin.git/upspin"
 )
@@ -76,6 +82,188 @@ func TestPack521(t *testing.T) {
 	testPackAndUnpack(t, ctx, packer, name, []byte(text))
 }
 
+func TestPackChaCha(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.chacha")
+		text                    = "this is some text chacha"
+		packing                 = upspin.EEChaChaP256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+	testPackAndUnpack(t, ctx, packer, name, []byte(text))
+}
+
+func TestPackChunked(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.chunked")
+		packing                 = upspin.EEp256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+
+	text := strings.Repeat("0123456789abcdef", 100) // spans several small chunks.
+	meta := &upspin.Metadata{ChunkSize: 64}
+	cipher := packBlob(t, ctx, packer, name, meta, []byte(text))
+
+	clear := unpackBlob(t, ctx, packer, name, meta, cipher)
+	if string(clear) != text {
+		t.Errorf("chunked text: expected %q; got %q", text, clear)
+	}
+}
+
+func TestPackChunkedTamper(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.tamper")
+		packing                 = upspin.EEp256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+
+	text := strings.Repeat("0123456789abcdef", 100)
+	meta := &upspin.Metadata{ChunkSize: 64}
+	cipher := packBlob(t, ctx, packer, name, meta, []byte(text))
+
+	// Flip a byte inside the second chunk's ciphertext; Unpack should
+	// fail the Merkle root (and hence the signature) check.
+	cipher[100] ^= 0xff
+
+	clear := make([]byte, packer.UnpackLen(ctx, cipher, meta))
+	if _, err := packer.Unpack(ctx, clear, cipher, meta, name); err == nil {
+		t.Fatal("Unpack: expected an error after tampering with a chunk, got none")
+	}
+}
+
+func TestUnpackChunk(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.unpackchunk")
+		packing                 = upspin.EEp256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+
+	plain := strings.Repeat("0123456789abcdef", 100) // several 64-byte chunks.
+	meta := &upspin.Metadata{ChunkSize: 64}
+	cipher := packBlob(t, ctx, packer, name, meta, []byte(plain))
+
+	const chunkLen = 64 + 16 // ChunkSize + AES-GCM overhead.
+	const i = 2
+	chunkCipher := cipher[i*chunkLen : (i+1)*chunkLen]
+
+	proof, err := packer.ChunkProof(meta, i)
+	if err != nil {
+		t.Fatal("ChunkProof: ", err)
+	}
+	clear, err := packer.UnpackChunk(ctx, meta, name, i, proof, chunkCipher)
+	if err != nil {
+		t.Fatal("UnpackChunk: ", err)
+	}
+	want := plain[i*64 : (i+1)*64]
+	if string(clear) != want {
+		t.Errorf("UnpackChunk: expected %q; got %q", want, clear)
+	}
+
+	// A tampered chunk must fail the Merkle-path check even though
+	// the proof itself is untouched.
+	tampered := append([]byte(nil), chunkCipher...)
+	tampered[0] ^= 0xff
+	if _, err := packer.UnpackChunk(ctx, meta, name, i, proof, tampered); err == nil {
+		t.Fatal("UnpackChunk: expected a Merkle proof failure for a tampered chunk, got none")
+	}
+}
+
+func TestUnpackMissingSignature(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.nosig")
+		packing                 = upspin.EEp256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+
+	meta := &upspin.Metadata{}
+	cipher := packBlob(t, ctx, packer, name, meta, []byte("sign me"))
+
+	// A crafted PackData that decodes fine but carries no signature
+	// (R, S are nil) must be rejected, not crash ecdsa.Verify.
+	pm, err := decodePackMeta(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.R, pm.S = nil, nil
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(pm); err != nil {
+		t.Fatal(err)
+	}
+	meta.PackData = buf.Bytes()
+
+	clear := make([]byte, packer.UnpackLen(ctx, cipher, meta))
+	if _, err := packer.Unpack(ctx, clear, cipher, meta, name); err == nil {
+		t.Fatal("Unpack: expected an error for a missing signature, got none")
+	}
+}
+
+func TestUnpackOffCurveEphemeral(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.offcurve")
+		packing                 = upspin.EEp256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+
+	meta := &upspin.Metadata{}
+	cipher := packBlob(t, ctx, packer, name, meta, []byte("sign me"))
+
+	// A crafted PackData whose Ephemeral point isn't on the curve must
+	// be rejected, not crash ScalarMult.
+	pm, err := decodePackMeta(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Ephemeral = upspin.PublicKey("1\n1")
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(pm); err != nil {
+		t.Fatal(err)
+	}
+	meta.PackData = buf.Bytes()
+
+	clear := make([]byte, packer.UnpackLen(ctx, cipher, meta))
+	if _, err := packer.Unpack(ctx, clear, cipher, meta, name); err == nil {
+		t.Fatal("Unpack: expected an error for an off-curve Ephemeral point, got none")
+	}
+}
+
+func TestPackDataKeyEntropy(t *testing.T) {
+	const (
+		user    upspin.UserName = "user@google.com"
+		name                    = upspin.PathName(user + "/file/of/user.entropy")
+		packing                 = upspin.EEp256Pack
+	)
+	ctx, packer := setup(t, user, packing)
+
+	cr := &countingReader{}
+	saved := dataKeySource
+	dataKeySource = cr
+	defer func() { dataKeySource = saved }()
+
+	meta := &upspin.Metadata{}
+	packBlob(t, ctx, packer, name, meta, []byte("entropy test"))
+
+	if cr.n != dkeyLen {
+		t.Errorf("Pack read %d bytes from the data-key source; want exactly %d, unmixed with any other source", cr.n, dkeyLen)
+	}
+}
+
+// countingReader is a deterministic io.Reader that records how many
+// bytes were requested of it.
+type countingReader struct{ n int }
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(i)
+	}
+	c.n += len(p)
+	return len(p), nil
+}
+
 func TestLoadingRemoteKeys(t *testing.T) {
 	// dude@google.com is the owner of a file that is shared with bob@foo.com.
 	const (
@@ -101,7 +289,7 @@ func TestLoadingRemoteKeys(t *testing.T) {
 		userToMatch: []upspin.UserName{bobsUserName, dudesUserName},
 		keyToReturn: []upspin.PublicKey{bobsPrivKey.Public, dudesPrivKey.Public},
 	}
-	ctx.PrivateKey = dudesPrivKey // Override setup to prevent reading keys from .ssh/
+	ctx.PrivateKey = dudesPrivKey // Override setup's generated key with Dude's fixed one.
 	ctx.User = mockUser
 
 	// Setup the metadata such that Bob is a reader.
@@ -124,23 +312,43 @@ func TestLoadingRemoteKeys(t *testing.T) {
 		t.Errorf("Expected %s, got %s", text, clear)
 	}
 
-	// Finally, check that unpack looked up Dude's public key, to verify the signature.
+	// Bob is reading a file he doesn't own, so Unpack had to resolve
+	// Dude's real public key via User.Lookup to check the signature
+	// against it, rather than trusting whatever PackData claimed;
+	// that's the one additional lookup beyond the one Pack made.
 	if mockUser.returnedKeys != 2 {
-		t.Fatal("Packer failed to request dude's public key")
+		t.Fatalf("Packer made %d User.Lookup calls in total; want 2", mockUser.returnedKeys)
 	}
 }
 
 func setup(t *testing.T, name upspin.UserName, packing upspin.Packing) (*upspin.Context, upspin.Packer) {
 	ctx := &upspin.Context{
-		UserName: name,
-		Packing:  packing,
+		UserName:   name,
+		Packing:    packing,
+		PrivateKey: generateKeyPair(t, packing),
 	}
 	packer := pack.Lookup(packing)
-	err := keyloader.Load(ctx)
+	return ctx, packer
+}
+
+// generateKeyPair returns a fresh key pair on the curve that packing
+// uses. Earlier versions of this helper relied on keyloader.Load to
+// read a legacy key pair from $HOME/.ssh, which doesn't exist on a
+// clean checkout and, even when present, is a single curve's key
+// reused for both p256 and p521 tests, crashing the p521 ones.
+func generateKeyPair(t *testing.T, packing upspin.Packing) upspin.PrivateKey {
+	curve := elliptic.P256()
+	if packing == upspin.EEp521Pack {
+		curve = elliptic.P521()
+	}
+	priv, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		t.Fatal(err)
 	}
-	return ctx, packer
+	return upspin.PrivateKey{
+		Public:  upspin.PublicKey(fmt.Sprintf("%s\n%s", x.String(), y.String())),
+		Private: []byte(new(big.Int).SetBytes(priv).String()),
+	}
 }
 
 // dummyUser is a User service that returns a key for a given user.
@@ -0,0 +1,99 @@
+package ee
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// merkleHashSize is the length to which SHA-256 node hashes are
+// truncated, tmhash-style; 20 bytes of a cryptographic hash is ample
+// collision resistance for Merkle proofs and keeps proofs small.
+const merkleHashSize = 20
+
+// merkleLeaf hashes a single chunk's ciphertext into a tree leaf,
+// under a 0x00 domain-separation prefix (see merkleNode).
+func merkleLeaf(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	sum := h.Sum(nil)
+	return sum[:merkleHashSize]
+}
+
+// merkleNode combines two child hashes into their parent. The 0x01
+// domain-separation prefix, against merkleLeaf's 0x00, keeps a leaf
+// hash from ever being mistaken for an internal node hash.
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	sum := h.Sum(nil)
+	return sum[:merkleHashSize]
+}
+
+// merkleRoot computes the root hash over a list of leaf hashes. An
+// odd hash at any level is promoted unchanged to the next level
+// rather than duplicated, so the tree shape is determined solely by
+// the chunk count.
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return merkleLeaf(nil)
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = merkleLevelUp(level)
+	}
+	return level[0]
+}
+
+func merkleLevelUp(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, merkleNode(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+// merkleProof returns the inclusion proof for leaf i: one entry per
+// tree level, holding that level's sibling hash, or nil when i's
+// hash was promoted unchanged (no sibling) at that level.
+func merkleProof(leaves [][]byte, i int) [][]byte {
+	var proof [][]byte
+	level, idx := leaves, i
+	for len(level) > 1 {
+		var sibling []byte
+		switch {
+		case idx%2 == 0 && idx+1 < len(level):
+			sibling = level[idx+1]
+		case idx%2 == 1:
+			sibling = level[idx-1]
+		}
+		proof = append(proof, sibling)
+		level = merkleLevelUp(level)
+		idx /= 2
+	}
+	return proof
+}
+
+// merkleVerify recomputes the root from leaf at index i and its
+// proof, and reports whether it matches root.
+func merkleVerify(leaf []byte, i int, proof [][]byte, root []byte) bool {
+	cur, idx := leaf, i
+	for _, sibling := range proof {
+		switch {
+		case sibling == nil:
+			// Promoted unchanged; cur is untouched.
+		case idx%2 == 0:
+			cur = merkleNode(cur, sibling)
+		default:
+			cur = merkleNode(sibling, cur)
+		}
+		idx /= 2
+	}
+	return bytes.Equal(cur, root)
+}
@@ -0,0 +1,28 @@
+// Package pack provides a registry of upspin.Packer implementations,
+// indexed by upspin.Packing.
+package pack
+
+import (
+	"fmt"
+
+	"upspin.googlesource.com/upspin.git/upspin"
+)
+
+var registration = make(map[upspin.Packing]upspin.Packer)
+
+// Register records the Packer under its Packing for later lookup. It
+// is typically called from the init function of a Packer's package.
+// It panics if the Packing is already registered.
+func Register(packer upspin.Packer) {
+	packing := packer.Packing()
+	if _, ok := registration[packing]; ok {
+		panic(fmt.Sprintf("pack: cannot register packing %v; already registered", packing))
+	}
+	registration[packing] = packer
+}
+
+// Lookup returns the Packer registered for the given Packing, or nil
+// if none is registered.
+func Lookup(packing upspin.Packing) upspin.Packer {
+	return registration[packing]
+}
@@ -0,0 +1,150 @@
+// Package upspin contains global interfaces and types used across the
+// Upspin system. Every other package in the tree depends, directly or
+// indirectly, on the definitions here.
+package upspin
+
+import "crypto/subtle"
+
+// UserName identifies a user, such as "user@google.com".
+type UserName string
+
+// PathName identifies a path in the Upspin name space, such as
+// "user@google.com/dir/file".
+type PathName string
+
+// Packing identifies the technique for turning the data and metadata
+// for a file into a sequence of bytes to be stored in a Store.
+type Packing int
+
+const (
+	// PlainPack stores data without packing, for tests and debugging.
+	PlainPack Packing = iota
+	// DebugPack is another trivial packing for tests.
+	DebugPack
+	// EEp256Pack packs data using elliptic-curve cryptography over
+	// curve P256 for signatures and key exchange.
+	EEp256Pack
+	// EEp521Pack is like EEp256Pack but uses curve P521.
+	EEp521Pack
+	// EEChaChaP256Pack is like EEp256Pack but seals content with
+	// XChaCha20-Poly1305 instead of AES-GCM, for speed and
+	// constant-time safety on hardware without AES-NI.
+	EEChaChaP256Pack
+)
+
+// PublicKey is a string representation of a user's public key.
+type PublicKey string
+
+// PrivateKey holds both halves of an Upspin key pair. Public is the
+// string representation used throughout the system; Private holds the
+// key's sensitive scalar, as a decimal string encoded in bytes.
+type PrivateKey struct {
+	Public  PublicKey
+	Private []byte
+}
+
+// Equals reports whether p and q hold the same key pair. The
+// comparison of the private scalar runs in constant time, so that
+// code comparing keys received from elsewhere (for example, to
+// detect a reused or stale key) does not leak timing information
+// about the secret.
+func (p PrivateKey) Equals(q PrivateKey) bool {
+	if p.Public != q.Public {
+		return false
+	}
+	return subtle.ConstantTimeCompare(p.Private, q.Private) == 1
+}
+
+// Endpoint identifies a network service, such as a User, Directory or
+// Store server.
+type Endpoint struct {
+	Transport int32
+	NetAddr   string
+}
+
+// Metadata holds the metadata associated with a file stored through
+// Upspin, including the list of users with whom it is shared and any
+// packing-specific data required to unpack it.
+type Metadata struct {
+	// Readers is the list of users, beyond the owner, who may read
+	// this file.
+	Readers []UserName
+	// PackData holds packing-specific data, such as wrapped keys and
+	// signatures, produced by Pack and consumed by Unpack.
+	PackData []byte
+
+	// ChunkSize, if non-zero, overrides a chunking Packer's default
+	// chunk size (in bytes) for this file. Packers that do not
+	// support chunking ignore it.
+	ChunkSize int
+}
+
+// User is the interface presented by the user service, which maps user
+// names to the servers that hold their roots and to their public keys.
+type User interface {
+	// Lookup returns the set of endpoints and public keys known for
+	// the named user.
+	Lookup(userName UserName) ([]Endpoint, []PublicKey, error)
+	// Dial connects to the User service described by the endpoint.
+	Dial(ctx *Context, e Endpoint) (interface{}, error)
+	// ServerUserName returns the name under which the server is
+	// running, for logging and debugging.
+	ServerUserName() string
+}
+
+// Context holds the information needed to run an Upspin client:
+// the user's identity, key pair and the services it talks to.
+type Context struct {
+	UserName UserName
+	Packing  Packing
+
+	// PrivateKey is the user's private key. It is normally populated
+	// by key/keyloader, not set directly by callers.
+	PrivateKey PrivateKey
+
+	// Passphrase, if non-empty, is used instead of prompting the
+	// user when a passphrase is required to unlock a keystore.
+	Passphrase string
+
+	User User
+}
+
+// Packer packs and unpacks data and metadata for a particular Packing.
+type Packer interface {
+	// Packing returns the Packing type this Packer implements.
+	Packing() Packing
+
+	// PackLen returns an upper bound on the number of bytes required
+	// to store the packed form of data for the named path.
+	PackLen(ctx *Context, data []byte, meta *Metadata, name PathName) int
+
+	// UnpackLen returns an upper bound on the number of bytes
+	// required to store the unpacked form of cipher.
+	UnpackLen(ctx *Context, cipher []byte, meta *Metadata) int
+
+	// Pack packs data into cipher, updating meta as needed, and
+	// returns the number of bytes written to cipher.
+	Pack(ctx *Context, cipher, data []byte, meta *Metadata, name PathName) (int, error)
+
+	// Unpack unpacks cipher into clear and returns the number of
+	// bytes written to clear.
+	Unpack(ctx *Context, clear, cipher []byte, meta *Metadata, name PathName) (int, error)
+
+	// UnpackChunk verifies and decrypts a single chunk of a
+	// chunk-packed file (see Metadata.ChunkSize) against the
+	// Merkle-path proof returned alongside it, without requiring
+	// any of the file's other chunks. Packers that do not support
+	// chunking may return an error.
+	UnpackChunk(ctx *Context, meta *Metadata, name PathName, chunk int, proof [][]byte, cipher []byte) ([]byte, error)
+
+	// ChunkProof returns the Merkle-path proof for the given chunk
+	// of a chunk-packed file, for passing to UnpackChunk. Packers
+	// that do not support chunking may return an error.
+	ChunkProof(meta *Metadata, chunk int) ([][]byte, error)
+
+	// GenSharedKey derives the symmetric key shared between
+	// ctx.PrivateKey and pub, for Packers that wrap per-file keys
+	// with an ECDH-style key agreement. It is exposed so that such
+	// Packers can share the primitive instead of reimplementing it.
+	GenSharedKey(ctx *Context, pub PublicKey) ([]byte, error)
+}